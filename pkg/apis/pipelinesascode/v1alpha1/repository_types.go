@@ -0,0 +1,50 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// Repository is the Schema for the Repositories API: it represents a Git
+// repository Pipelines-as-Code watches for events and the settings that
+// govern how its PipelineRuns are admitted and run.
+type Repository struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec RepositorySpec `json:"spec,omitempty"`
+}
+
+// RepositorySpec controls concurrency and queueing of PipelineRuns
+// created for this Repository.
+type RepositorySpec struct {
+	// ConcurrencyLimit caps how many PipelineRuns for this repository may
+	// be running at once; additional runs wait in the queue. Nil or zero
+	// means unlimited.
+	ConcurrencyLimit *int `json:"concurrency_limit,omitempty"`
+
+	// ConcurrencyMaxWait bounds how old (measured from its creation
+	// timestamp) a PipelineRun may be while still sitting in the waiting
+	// queue before it is evicted and transitioned to Cancelled
+	// with reason QueueTimeout. Nil (or zero) means a run may wait
+	// indefinitely.
+	ConcurrencyMaxWait *metav1.Duration `json:"concurrency_max_wait,omitempty"`
+
+	// QueuePriority is the default queue priority for PipelineRuns
+	// against this repository that don't carry their own
+	// pipelinesascode.tekton.dev/queue-priority annotation. Higher values
+	// run sooner; nil is treated as priority 0.
+	QueuePriority *int `json:"queue_priority,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RepositoryList is a list of Repository resources.
+type RepositoryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []Repository `json:"items"`
+}
@@ -0,0 +1,78 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// defaultLeaseTTL bounds how long an admitted PipelineRun may hold its
+// slot without being renewed before it is considered abandoned (e.g. the
+// controller that admitted it crashed).
+const defaultLeaseTTL = 2 * time.Minute
+
+// ErrLeaseLost is returned by Renew when member is no longer held (its
+// lease already expired and was reclaimed by a TryAcquire elsewhere).
+// Renew must never resurrect such a member as a side effect of renewing
+// it; the caller has lost its slot and must re-enter the queue.
+var ErrLeaseLost = errors.New("sync: lease lost, member is no longer held")
+
+// BackendKind selects which QueueBackend implementation NewQueueManager
+// wires up, driven by the `concurrency-backend` setting.
+type BackendKind string
+
+const (
+	// BackendMemory keeps all concurrency state local to this process.
+	// It is the default and is correct as long as a single controller
+	// replica is running.
+	BackendMemory BackendKind = "memory"
+	// BackendRedis stores concurrency state in Redis so that several
+	// controller replicas share the same limits.
+	BackendRedis BackendKind = "redis"
+)
+
+// ParseBackendKind validates the `concurrency-backend` config value,
+// defaulting to BackendMemory when unset.
+func ParseBackendKind(value string) (BackendKind, error) {
+	switch BackendKind(value) {
+	case "", BackendMemory:
+		return BackendMemory, nil
+	case BackendRedis:
+		return BackendRedis, nil
+	default:
+		return "", fmt.Errorf("unknown concurrency-backend %q, expected %q or %q", value, BackendMemory, BackendRedis)
+	}
+}
+
+// QueueBackend owns the actual admission decision for a repository's
+// concurrency slots. A Semaphore keeps local FIFO/priority ordering of
+// who should be asking, but whether an ask succeeds is delegated here so
+// that an implementation can share the slot count across controller
+// replicas (see BackendRedis).
+//
+// repoKey identifies the repository (namespace/name), member identifies
+// the PipelineRun or Run (namespace/name) trying to acquire or holding a
+// slot.
+type QueueBackend interface {
+	// TryAcquire attempts to admit member into repoKey's running set,
+	// bounded by limit. It returns the current queue position (1-indexed
+	// amongst held slots) when admission fails, for diagnostics.
+	TryAcquire(ctx context.Context, repoKey, member string, ttl time.Duration, limit int) (acquired bool, position int, err error)
+	// Renew extends member's lease so it isn't considered abandoned. It
+	// only touches a lease that is still held: if member's lease already
+	// expired and was reclaimed, Renew must not resurrect it, and returns
+	// ErrLeaseLost instead.
+	Renew(ctx context.Context, repoKey, member string, ttl time.Duration) error
+	// Release gives up member's slot, if it holds one.
+	Release(ctx context.Context, repoKey, member string) error
+	// Resize updates the limit enforced for repoKey.
+	Resize(ctx context.Context, repoKey string, limit int) error
+	// Force unconditionally grants member a slot for repoKey, even if
+	// that pushes the holder count above the configured limit. Used by
+	// the queue-request=force escape hatch.
+	Force(ctx context.Context, repoKey, member string, ttl time.Duration) error
+	// Holders lists the members currently holding a slot for repoKey,
+	// used by InitQueues to reconcile in-process state on startup.
+	Holders(ctx context.Context, repoKey string) ([]string, error)
+}
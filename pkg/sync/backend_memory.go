@@ -0,0 +1,119 @@
+package sync
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryBackend is the non-HA QueueBackend: a plain in-process map of
+// leases per repository. It is equivalent in behaviour to the original
+// (pre-QueueBackend) semaphore admission logic.
+type memoryBackend struct {
+	mu    sync.Mutex
+	repos map[string]*memoryRepoState
+}
+
+type memoryRepoState struct {
+	limit  int
+	leases map[string]time.Time // member -> expiry
+}
+
+func newMemoryBackend() QueueBackend {
+	return &memoryBackend{repos: map[string]*memoryRepoState{}}
+}
+
+func (m *memoryBackend) state(repoKey string, limit int) *memoryRepoState {
+	st, ok := m.repos[repoKey]
+	if !ok {
+		st = &memoryRepoState{limit: limit, leases: map[string]time.Time{}}
+		m.repos[repoKey] = st
+	}
+	return st
+}
+
+func (m *memoryBackend) evictExpired(st *memoryRepoState, now time.Time) {
+	for member, expiry := range st.leases {
+		if now.After(expiry) {
+			delete(st.leases, member)
+		}
+	}
+}
+
+func (m *memoryBackend) TryAcquire(_ context.Context, repoKey, member string, ttl time.Duration, limit int) (bool, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st := m.state(repoKey, limit)
+	st.limit = limit
+	now := time.Now()
+	m.evictExpired(st, now)
+
+	if _, held := st.leases[member]; held {
+		st.leases[member] = now.Add(ttl)
+		return true, 0, nil
+	}
+	if len(st.leases) >= st.limit {
+		return false, len(st.leases) + 1, nil
+	}
+	st.leases[member] = now.Add(ttl)
+	return true, 0, nil
+}
+
+func (m *memoryBackend) Renew(_ context.Context, repoKey, member string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st, ok := m.repos[repoKey]
+	if !ok {
+		return ErrLeaseLost
+	}
+	if _, held := st.leases[member]; !held {
+		return ErrLeaseLost
+	}
+	st.leases[member] = time.Now().Add(ttl)
+	return nil
+}
+
+func (m *memoryBackend) Release(_ context.Context, repoKey, member string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if st, ok := m.repos[repoKey]; ok {
+		delete(st.leases, member)
+	}
+	return nil
+}
+
+func (m *memoryBackend) Resize(_ context.Context, repoKey string, limit int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.state(repoKey, limit).limit = limit
+	return nil
+}
+
+func (m *memoryBackend) Force(_ context.Context, repoKey, member string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st := m.state(repoKey, 0)
+	st.leases[member] = time.Now().Add(ttl)
+	return nil
+}
+
+func (m *memoryBackend) Holders(_ context.Context, repoKey string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st, ok := m.repos[repoKey]
+	if !ok {
+		return nil, nil
+	}
+	m.evictExpired(st, time.Now())
+	holders := make([]string, 0, len(st.leases))
+	for member := range st.leases {
+		holders = append(holders, member)
+	}
+	return holders, nil
+}
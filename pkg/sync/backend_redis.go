@@ -0,0 +1,144 @@
+package sync
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisBackend shares concurrency state across controller replicas by
+// keeping each repository's holders in a Redis sorted set, keyed by
+// <ns>/<repo>, with members <ns>/<pipelinerun-or-run> and scores set to
+// the lease's expiry (unix milliseconds). This mirrors the pattern used
+// by asynq's x/rate Lua-scripted semaphore.
+type redisBackend struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisBackend builds a QueueBackend backed by the given Redis
+// client. prefix namespaces the keys this controller installation uses,
+// so multiple PaC installs can share a Redis instance.
+func NewRedisBackend(client redis.UniversalClient, prefix string) QueueBackend {
+	return &redisBackend{client: client, prefix: prefix}
+}
+
+func (r *redisBackend) key(repoKey string) string {
+	return r.prefix + repoKey
+}
+
+func (r *redisBackend) limitKey(repoKey string) string {
+	return r.prefix + repoKey + ":limit"
+}
+
+// tryAcquireScript is a single atomic operation: drop expired members,
+// and if the set still has room admit the caller (or renew it if it is
+// already a member), otherwise report the caller's position.
+//
+// KEYS[1] = sorted set of holders for the repository
+// KEYS[2] = limit key (falls back to ARGV[3] if unset)
+// ARGV[1] = member
+// ARGV[2] = now (unix ms)
+// ARGV[3] = default limit
+// ARGV[4] = lease expiry (unix ms)
+var tryAcquireScript = redis.NewScript(`
+local holders = KEYS[1]
+local limitKey = KEYS[2]
+local member = ARGV[1]
+local now = tonumber(ARGV[2])
+local defaultLimit = tonumber(ARGV[3])
+local expiry = tonumber(ARGV[4])
+
+redis.call('ZREMRANGEBYSCORE', holders, '-inf', now)
+
+local limit = tonumber(redis.call('GET', limitKey))
+if not limit then
+	limit = defaultLimit
+end
+
+local score = redis.call('ZSCORE', holders, member)
+if score then
+	redis.call('ZADD', holders, expiry, member)
+	return {1, 0}
+end
+
+local count = redis.call('ZCARD', holders)
+if count < limit then
+	redis.call('ZADD', holders, expiry, member)
+	return {1, 0}
+end
+
+return {0, count + 1}
+`)
+
+func (r *redisBackend) TryAcquire(ctx context.Context, repoKey, member string, ttl time.Duration, limit int) (bool, int, error) {
+	now := time.Now()
+	res, err := tryAcquireScript.Run(ctx, r.client,
+		[]string{r.key(repoKey), r.limitKey(repoKey)},
+		member, now.UnixMilli(), limit, now.Add(ttl).UnixMilli(),
+	).Slice()
+	if err != nil {
+		return false, 0, err
+	}
+
+	acquired, _ := res[0].(int64)
+	position, _ := res[1].(int64)
+	return acquired == 1, int(position), nil
+}
+
+// renewScript renews member's lease only if it is still present in the
+// holders set. A plain ZADD would insert member if it had already been
+// evicted by tryAcquireScript's ZREMRANGEBYSCORE, resurrecting a slot
+// another run may have since taken and pushing ZCARD above limit.
+//
+// KEYS[1] = sorted set of holders for the repository
+// ARGV[1] = member
+// ARGV[2] = lease expiry (unix ms)
+var renewScript = redis.NewScript(`
+local holders = KEYS[1]
+local member = ARGV[1]
+local expiry = tonumber(ARGV[2])
+
+if redis.call('ZSCORE', holders, member) then
+	redis.call('ZADD', holders, expiry, member)
+	return 1
+end
+
+return 0
+`)
+
+func (r *redisBackend) Renew(ctx context.Context, repoKey, member string, ttl time.Duration) error {
+	renewed, err := renewScript.Run(ctx, r.client, []string{r.key(repoKey)}, member, time.Now().Add(ttl).UnixMilli()).Int()
+	if err != nil {
+		return err
+	}
+	if renewed == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (r *redisBackend) Release(ctx context.Context, repoKey, member string) error {
+	return r.client.ZRem(ctx, r.key(repoKey), member).Err()
+}
+
+func (r *redisBackend) Resize(ctx context.Context, repoKey string, limit int) error {
+	return r.client.Set(ctx, r.limitKey(repoKey), strconv.Itoa(limit), 0).Err()
+}
+
+func (r *redisBackend) Force(ctx context.Context, repoKey, member string, ttl time.Duration) error {
+	return r.client.ZAdd(ctx, r.key(repoKey), redis.Z{
+		Score:  float64(time.Now().Add(ttl).UnixMilli()),
+		Member: member,
+	}).Err()
+}
+
+func (r *redisBackend) Holders(ctx context.Context, repoKey string) ([]string, error) {
+	now := time.Now().UnixMilli()
+	return r.client.ZRangeByScore(ctx, r.key(repoKey), &redis.ZRangeBy{
+		Min: strconv.FormatInt(now, 10),
+		Max: "+inf",
+	}).Result()
+}
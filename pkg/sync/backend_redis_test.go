@@ -0,0 +1,129 @@
+package sync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"gotest.tools/v3/assert"
+)
+
+// newTestRedisBackend spins up an in-process miniredis server, since
+// this tree has no real Redis available to test against.
+func newTestRedisBackend(t *testing.T) QueueBackend {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return NewRedisBackend(client, "pac:")
+}
+
+func TestRedisBackend_TryAcquireRespectsLimit(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestRedisBackend(t)
+
+	acquired, _, err := backend.TryAcquire(ctx, "ns/repo", "ns/run1", time.Minute, 1)
+	assert.NilError(t, err)
+	assert.Assert(t, acquired)
+
+	acquired, position, err := backend.TryAcquire(ctx, "ns/repo", "ns/run2", time.Minute, 1)
+	assert.NilError(t, err)
+	assert.Assert(t, !acquired)
+	assert.Equal(t, position, 2)
+}
+
+func TestRedisBackend_TryAcquireReadmitsExistingMember(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestRedisBackend(t)
+
+	acquired, _, err := backend.TryAcquire(ctx, "ns/repo", "ns/run1", time.Minute, 1)
+	assert.NilError(t, err)
+	assert.Assert(t, acquired)
+
+	acquired, _, err = backend.TryAcquire(ctx, "ns/repo", "ns/run1", time.Minute, 1)
+	assert.NilError(t, err)
+	assert.Assert(t, acquired, "an already-admitted member retrying TryAcquire should stay admitted")
+}
+
+func TestRedisBackend_TryAcquireReclaimsExpiredLease(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestRedisBackend(t)
+
+	acquired, _, err := backend.TryAcquire(ctx, "ns/repo", "ns/run1", time.Millisecond, 1)
+	assert.NilError(t, err)
+	assert.Assert(t, acquired)
+
+	time.Sleep(5 * time.Millisecond)
+
+	acquired, _, err = backend.TryAcquire(ctx, "ns/repo", "ns/run2", time.Minute, 1)
+	assert.NilError(t, err)
+	assert.Assert(t, acquired, "an expired lease should be reclaimed by the next acquirer")
+}
+
+func TestRedisBackend_Release(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestRedisBackend(t)
+
+	_, _, err := backend.TryAcquire(ctx, "ns/repo", "ns/run1", time.Minute, 1)
+	assert.NilError(t, err)
+
+	assert.NilError(t, backend.Release(ctx, "ns/repo", "ns/run1"))
+
+	acquired, _, err := backend.TryAcquire(ctx, "ns/repo", "ns/run2", time.Minute, 1)
+	assert.NilError(t, err)
+	assert.Assert(t, acquired, "releasing a holder should free its slot")
+}
+
+func TestRedisBackend_RenewDoesNotResurrectAnEvictedMember(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestRedisBackend(t)
+
+	acquired, _, err := backend.TryAcquire(ctx, "ns/repo", "ns/run1", time.Millisecond, 1)
+	assert.NilError(t, err)
+	assert.Assert(t, acquired)
+
+	time.Sleep(5 * time.Millisecond)
+
+	// run2 reclaims the slot run1's expired lease freed up.
+	acquired, _, err = backend.TryAcquire(ctx, "ns/repo", "ns/run2", time.Minute, 1)
+	assert.NilError(t, err)
+	assert.Assert(t, acquired)
+
+	// run1's heartbeat firing after the fact must not resurrect it and
+	// push the holder count above the limit.
+	err = backend.Renew(ctx, "ns/repo", "ns/run1", time.Minute)
+	assert.Equal(t, err, ErrLeaseLost)
+
+	holders, err := backend.Holders(ctx, "ns/repo")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, holders, []string{"ns/run2"})
+}
+
+func TestRedisBackend_RenewExtendsAHeldLease(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestRedisBackend(t)
+
+	_, _, err := backend.TryAcquire(ctx, "ns/repo", "ns/run1", 10*time.Millisecond, 1)
+	assert.NilError(t, err)
+
+	assert.NilError(t, backend.Renew(ctx, "ns/repo", "ns/run1", time.Minute))
+
+	time.Sleep(20 * time.Millisecond)
+
+	acquired, _, err := backend.TryAcquire(ctx, "ns/repo", "ns/run2", time.Minute, 1)
+	assert.NilError(t, err)
+	assert.Assert(t, !acquired, "run1's lease was renewed well past its original TTL, so run2 should still be blocked")
+}
+
+func TestRedisBackend_Force(t *testing.T) {
+	ctx := context.Background()
+	backend := newTestRedisBackend(t)
+
+	assert.NilError(t, backend.Force(ctx, "ns/repo", "ns/run1", time.Minute))
+
+	holders, err := backend.Holders(ctx, "ns/repo")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, holders, []string{"ns/run1"})
+}
@@ -0,0 +1,63 @@
+package sync
+
+import (
+	"context"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// Metrics are plain OpenCensus views; the binary's knative.dev/pkg/metrics
+// exporter (wired up in cmd/pipelines-as-code-controller) turns these into
+// the Prometheus series pac_queue_waiting, pac_queue_running,
+// pac_queue_limit, pac_queue_wait_seconds, pac_queue_admitted_total and
+// pac_queue_removed_total, one series per repository.
+var (
+	repositoryTagKey = tag.MustNewKey("repository")
+
+	queueWaitingCount  = stats.Int64("pac_queue_waiting", "number of PipelineRuns waiting in a repository's queue", stats.UnitDimensionless)
+	queueRunningCount  = stats.Int64("pac_queue_running", "number of PipelineRuns currently running for a repository", stats.UnitDimensionless)
+	queueLimit         = stats.Int64("pac_queue_limit", "configured ConcurrencyLimit for a repository", stats.UnitDimensionless)
+	queueWaitSeconds   = stats.Float64("pac_queue_wait_seconds", "seconds a PipelineRun spent waiting before being admitted", stats.UnitSeconds)
+	queueAdmittedTotal = stats.Int64("pac_queue_admitted_total", "total PipelineRuns admitted from a repository's queue", stats.UnitDimensionless)
+	queueRemovedTotal  = stats.Int64("pac_queue_removed_total", "total PipelineRuns removed from a repository's waiting or running queue", stats.UnitDimensionless)
+)
+
+func init() {
+	err := view.Register(
+		&view.View{Measure: queueWaitingCount, Aggregation: view.LastValue(), TagKeys: []tag.Key{repositoryTagKey}},
+		&view.View{Measure: queueRunningCount, Aggregation: view.LastValue(), TagKeys: []tag.Key{repositoryTagKey}},
+		&view.View{Measure: queueLimit, Aggregation: view.LastValue(), TagKeys: []tag.Key{repositoryTagKey}},
+		&view.View{Measure: queueWaitSeconds, Aggregation: view.Distribution(0, 1, 5, 15, 30, 60, 120, 300, 900, 1800), TagKeys: []tag.Key{repositoryTagKey}},
+		&view.View{Measure: queueAdmittedTotal, Aggregation: view.Count(), TagKeys: []tag.Key{repositoryTagKey}},
+		&view.View{Measure: queueRemovedTotal, Aggregation: view.Count(), TagKeys: []tag.Key{repositoryTagKey}},
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func recordQueueGauges(repoKey string, waiting, running, limit int) {
+	ctx, err := tag.New(context.Background(), tag.Upsert(repositoryTagKey, repoKey))
+	if err != nil {
+		return
+	}
+	stats.Record(ctx, queueWaitingCount.M(int64(waiting)), queueRunningCount.M(int64(running)), queueLimit.M(int64(limit)))
+}
+
+func recordAdmitted(repoKey string, waitSeconds float64) {
+	ctx, err := tag.New(context.Background(), tag.Upsert(repositoryTagKey, repoKey))
+	if err != nil {
+		return
+	}
+	stats.Record(ctx, queueWaitSeconds.M(waitSeconds), queueAdmittedTotal.M(1))
+}
+
+func recordRemoved(repoKey string) {
+	ctx, err := tag.New(context.Background(), tag.Upsert(repositoryTagKey, repoKey))
+	if err != nil {
+		return
+	}
+	stats.Record(ctx, queueRemovedTotal.M(1))
+}
@@ -0,0 +1,60 @@
+package sync
+
+import (
+	"testing"
+
+	"go.opencensus.io/stats/view"
+	"gotest.tools/v3/assert"
+)
+
+// retrieveLastValue returns the LastValue recorded for measureName tagged
+// with repoKey, or 0 if nothing has been recorded for it yet.
+func retrieveLastValue(t *testing.T, measureName, repoKey string) float64 {
+	t.Helper()
+	rows, err := view.RetrieveData(measureName)
+	assert.NilError(t, err)
+	for _, row := range rows {
+		for _, tag := range row.Tags {
+			if tag.Key == repositoryTagKey && tag.Value == repoKey {
+				return row.Data.(*view.LastValueData).Value
+			}
+		}
+	}
+	return 0
+}
+
+// retrieveCount returns the Count recorded for measureName tagged with
+// repoKey, or 0 if nothing has been recorded for it yet.
+func retrieveCount(t *testing.T, measureName, repoKey string) int64 {
+	t.Helper()
+	rows, err := view.RetrieveData(measureName)
+	assert.NilError(t, err)
+	for _, row := range rows {
+		for _, tag := range row.Tags {
+			if tag.Key == repositoryTagKey && tag.Value == repoKey {
+				return row.Data.(*view.CountData).Value
+			}
+		}
+	}
+	return 0
+}
+
+func TestRecordQueueGauges(t *testing.T) {
+	repoKey := "ns/metrics-gauges"
+	recordQueueGauges(repoKey, 3, 1, 2)
+
+	assert.Equal(t, retrieveLastValue(t, "pac_queue_waiting", repoKey), float64(3))
+	assert.Equal(t, retrieveLastValue(t, "pac_queue_running", repoKey), float64(1))
+	assert.Equal(t, retrieveLastValue(t, "pac_queue_limit", repoKey), float64(2))
+}
+
+func TestRecordAdmittedAndRemoved(t *testing.T) {
+	repoKey := "ns/metrics-counters"
+
+	recordAdmitted(repoKey, 1.5)
+	recordRemoved(repoKey)
+	recordRemoved(repoKey)
+
+	assert.Equal(t, retrieveCount(t, "pac_queue_admitted_total", repoKey), int64(1))
+	assert.Equal(t, retrieveCount(t, "pac_queue_removed_total", repoKey), int64(2))
+}
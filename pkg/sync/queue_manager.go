@@ -3,30 +3,86 @@ package sync
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/apis/pipelinesascode"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/apis/pipelinesascode/v1alpha1"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/generated/clientset/versioned"
 	"github.com/openshift-pipelines/pipelines-as-code/pkg/kubeinteraction"
-	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	"github.com/redis/go-redis/v9"
 	versioned2 "github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
 	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 )
 
+// purgeExpiredInterval is how often the background QueueManager goroutine
+// sweeps waiting queues for entries past their Repository's
+// ConcurrencyMaxWait.
+const purgeExpiredInterval = 30 * time.Second
+
+// ExpiredQueueEntry identifies a waiting run that was evicted because it
+// exceeded its Repository's ConcurrencyMaxWait. The caller (reconciler)
+// is expected to transition it to Cancelled with reason QueueTimeout and
+// report the outcome via its provider.
+type ExpiredQueueEntry struct {
+	Repo     *v1alpha1.Repository
+	QueueKey string
+}
+
+// QueueTimeoutHandler is invoked for every entry purgeExpiredLoop evicts.
+type QueueTimeoutHandler func(ctx context.Context, entry ExpiredQueueEntry)
+
 type QueueManager struct {
-	queueMap map[string]Semaphore
-	lock     *sync.Mutex
-	logger   *zap.SugaredLogger
+	queueMap  map[string]Semaphore
+	repoByKey map[string]*v1alpha1.Repository
+	lock      *sync.Mutex
+	logger    *zap.SugaredLogger
+	backend   QueueBackend
+	recorder  record.EventRecorder
+	onTimeout QueueTimeoutHandler
 }
 
-func NewQueueManager(logger *zap.SugaredLogger) *QueueManager {
-	return &QueueManager{
-		queueMap: make(map[string]Semaphore),
-		lock:     &sync.Mutex{},
-		logger:   logger,
+// NewQueueManager creates a QueueManager backed by the given backend
+// kind. BackendMemory keeps all state in this process and is correct
+// for a single controller replica; BackendRedis shares admission state
+// across replicas via redisBackend and requires a non-nil redisClient.
+// recorder is used to emit Queued/Started/PromotedFromQueue/
+// RemovedFromQueue/QueueTimeout Events on the Repository object.
+// onTimeout, if non-nil, is called for every waiting run evicted for
+// exceeding its Repository's ConcurrencyMaxWait, so the caller can
+// cancel the run and report it through its provider; it may be nil, in
+// which case expired entries are still evicted and reported via Events.
+// A background goroutine, stopped when ctx is done, sweeps for such
+// expired entries every 30s.
+func NewQueueManager(ctx context.Context, logger *zap.SugaredLogger, kind BackendKind, redisClient redis.UniversalClient, redisKeyPrefix string, recorder record.EventRecorder, onTimeout QueueTimeoutHandler) (*QueueManager, error) {
+	var backend QueueBackend
+	switch kind {
+	case BackendRedis:
+		if redisClient == nil {
+			return nil, fmt.Errorf("concurrency-backend %q requires a redis client", BackendRedis)
+		}
+		backend = NewRedisBackend(redisClient, redisKeyPrefix)
+	case BackendMemory, "":
+		backend = newMemoryBackend()
+	default:
+		return nil, fmt.Errorf("unknown concurrency-backend %q", kind)
 	}
+
+	qm := &QueueManager{
+		queueMap:  make(map[string]Semaphore),
+		repoByKey: make(map[string]*v1alpha1.Repository),
+		lock:      &sync.Mutex{},
+		logger:    logger,
+		backend:   backend,
+		recorder:  recorder,
+		onTimeout: onTimeout,
+	}
+	go qm.purgeExpiredLoop(ctx)
+	return qm, nil
 }
 
 // getSemaphore returns existing semaphore created for repository or create
@@ -35,6 +91,7 @@ func NewQueueManager(logger *zap.SugaredLogger) *QueueManager {
 // with limit deciding how many should be running at a time
 func (qm *QueueManager) getSemaphore(repo *v1alpha1.Repository) (Semaphore, error) {
 	repoKey := repoKey(repo)
+	qm.repoByKey[repoKey] = repo
 
 	if sema, found := qm.queueMap[repoKey]; found {
 		if err := qm.checkAndUpdateSemaphoreSize(repo, sema); err != nil {
@@ -44,7 +101,9 @@ func (qm *QueueManager) getSemaphore(repo *v1alpha1.Repository) (Semaphore, erro
 	}
 
 	// create a new semaphore
-	qm.queueMap[repoKey] = newSemaphore(repoKey, *repo.Spec.ConcurrencyLimit)
+	sema := newSemaphore(repoKey, *repo.Spec.ConcurrencyLimit, qm.backend)
+	sema.setMaxWait(maxWaitDuration(repo))
+	qm.queueMap[repoKey] = sema
 
 	return qm.queueMap[repoKey], nil
 }
@@ -53,7 +112,18 @@ func repoKey(repo *v1alpha1.Repository) string {
 	return fmt.Sprintf("%s/%s", repo.Namespace, repo.Name)
 }
 
+// maxWaitDuration returns the configured ConcurrencyMaxWait, or zero
+// (meaning "never expire") when unset.
+func maxWaitDuration(repo *v1alpha1.Repository) time.Duration {
+	if repo.Spec.ConcurrencyMaxWait == nil {
+		return 0
+	}
+	return repo.Spec.ConcurrencyMaxWait.Duration
+}
+
 func (qm *QueueManager) checkAndUpdateSemaphoreSize(repo *v1alpha1.Repository, semaphore Semaphore) error {
+	semaphore.setMaxWait(maxWaitDuration(repo))
+
 	limit := *repo.Spec.ConcurrencyLimit
 	if limit != semaphore.getLimit() {
 		if semaphore.resize(limit) {
@@ -64,10 +134,51 @@ func (qm *QueueManager) checkAndUpdateSemaphoreSize(repo *v1alpha1.Repository, s
 	return nil
 }
 
+// purgeExpiredLoop periodically evicts waiting runs that exceeded their
+// Repository's ConcurrencyMaxWait, until ctx is done.
+func (qm *QueueManager) purgeExpiredLoop(ctx context.Context) {
+	ticker := time.NewTicker(purgeExpiredInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			qm.purgeExpiredOnce(ctx)
+		}
+	}
+}
+
+func (qm *QueueManager) purgeExpiredOnce(ctx context.Context) {
+	qm.lock.Lock()
+	var expired []ExpiredQueueEntry
+	now := time.Now()
+	for repoKey, sema := range qm.queueMap {
+		repo := qm.repoByKey[repoKey]
+		for _, qKey := range sema.purgeExpired(now) {
+			qm.logger.Infof("evicted (%s) from queue for repository (%s): exceeded ConcurrencyMaxWait", qKey, repoKey)
+			recordRemoved(repoKey)
+			if repo != nil {
+				qm.recorder.Eventf(repo, corev1.EventTypeWarning, "QueueTimeout", "PipelineRun %s exceeded ConcurrencyMaxWait and was removed from the queue", qKey)
+			}
+			expired = append(expired, ExpiredQueueEntry{Repo: repo, QueueKey: qKey})
+		}
+	}
+	qm.lock.Unlock()
+
+	if qm.onTimeout == nil {
+		return
+	}
+	for _, entry := range expired {
+		qm.onTimeout(ctx, entry)
+	}
+}
+
 // AddToQueue adds the pipelineRun to the waiting queue of the repository
 // and if it is at the top and ready to run which means currently running pipelineRun < limit
 // then move it to running queue
-func (qm *QueueManager) AddToQueue(repo *v1alpha1.Repository, run *v1beta1.PipelineRun) (bool, string, error) {
+func (qm *QueueManager) AddToQueue(ctx context.Context, repo *v1alpha1.Repository, run Queueable) (bool, string, error) {
 	qm.lock.Lock()
 	defer qm.lock.Unlock()
 
@@ -77,13 +188,15 @@ func (qm *QueueManager) AddToQueue(repo *v1alpha1.Repository, run *v1beta1.Pipel
 	}
 
 	qKey := getQueueKey(run)
-	sema.addToQueue(qKey, run.CreationTimestamp.Time)
+	sema.addToQueue(qKey, run.GetCreationTimestamp().Time, queuePriority(run, repo))
 
 	qm.logger.Infof("added pipelineRun (%s) to queue for repository (%s)", qKey, repoKey(repo))
+	qm.recorder.Eventf(repo, corev1.EventTypeNormal, "Queued", "PipelineRun %s has been added to the queue", qKey)
 
-	acquired, msg := sema.tryAcquire(qKey)
+	acquired, msg := sema.tryAcquire(ctx, qKey)
 	if acquired {
 		qm.logger.Infof("moved (%s) to running for repository (%s)", qKey, repoKey(repo))
+		qm.recorder.Eventf(repo, corev1.EventTypeNormal, "Started", "PipelineRun %s has started running", qKey)
 	}
 	return acquired, msg, nil
 }
@@ -91,35 +204,174 @@ func (qm *QueueManager) AddToQueue(repo *v1alpha1.Repository, run *v1beta1.Pipel
 // RemoveFromQueue removes the pipelineRun from the queues of the repository
 // It also start the next one which is on top of the waiting queue and return its name
 // if started or returns ""
-func (qm *QueueManager) RemoveFromQueue(repo *v1alpha1.Repository, run *v1beta1.PipelineRun) string {
+func (qm *QueueManager) RemoveFromQueue(ctx context.Context, repo *v1alpha1.Repository, run Queueable) string {
 	qm.lock.Lock()
 	defer qm.lock.Unlock()
 
+	return qm.removeAndAdvance(ctx, repo, getQueueKey(run))
+}
+
+// removeAndAdvance releases and dequeues qKey (a no-op for either side if
+// it was never held/queued), then advances the next waiting run into the
+// freed slot, if any. Shared by RemoveFromQueue and CancelQueued so that
+// cancelling a merely-queued run still promotes the correct next runner.
+func (qm *QueueManager) removeAndAdvance(ctx context.Context, repo *v1alpha1.Repository, qKey string) string {
 	repoKey := repoKey(repo)
 	sema, found := qm.queueMap[repoKey]
 	if !found {
 		return ""
 	}
 
-	qKey := getQueueKey(run)
 	sema.release(qKey)
 	sema.removeFromQueue(qKey)
 	qm.logger.Infof("removed (%s) for repository (%s)", qKey, repoKey)
+	recordRemoved(repoKey)
+	qm.recorder.Eventf(repo, corev1.EventTypeNormal, "RemovedFromQueue", "PipelineRun %s has been removed from the queue", qKey)
 
-	if next := sema.acquireLatest(); next != "" {
-		qm.logger.Infof("moved (%s) to running for repository (%s)", qKey, repoKey)
+	if next := sema.acquireLatest(ctx); next != "" {
+		qm.logger.Infof("moved (%s) to running for repository (%s)", next, repoKey)
+		qm.recorder.Eventf(repo, corev1.EventTypeNormal, "Started", "PipelineRun %s has started running", next)
 		return next
 	}
 	return ""
 }
 
-func getQueueKey(run *v1beta1.PipelineRun) string {
-	return fmt.Sprintf("%s/%s", run.Namespace, run.Name)
+// Promote implements queue-request=promote: it moves run to the head of
+// the waiting queue, ahead of today's (-priority, creationTimestamp)
+// ordering, and immediately attempts to admit it. It still respects
+// ConcurrencyLimit — it only skips ahead of other waiting runs.
+func (qm *QueueManager) Promote(ctx context.Context, repo *v1alpha1.Repository, run Queueable) (bool, string, error) {
+	qm.lock.Lock()
+	defer qm.lock.Unlock()
+
+	sema, err := qm.getSemaphore(repo)
+	if err != nil {
+		return false, "", err
+	}
+
+	qKey := getQueueKey(run)
+	sema.promote(qKey)
+	qm.logger.Infof("promoted (%s) to head of queue for repository (%s)", qKey, repoKey(repo))
+	qm.recorder.Eventf(repo, corev1.EventTypeNormal, "PromotedFromQueue", "PipelineRun %s has been promoted to the head of the queue", qKey)
+
+	acquired, msg := sema.tryAcquire(ctx, qKey)
+	if acquired {
+		qm.logger.Infof("moved (%s) to running for repository (%s)", qKey, repoKey(repo))
+		qm.recorder.Eventf(repo, corev1.EventTypeNormal, "Started", "PipelineRun %s has started running", qKey)
+	}
+	return acquired, msg, nil
+}
+
+// CancelQueued implements queue-request=cancel: it removes run from the
+// queue without ever admitting it, and returns the name of whichever
+// waiting run was promoted into the slot this freed up (if any). The
+// caller is responsible for marking run itself Cancelled.
+func (qm *QueueManager) CancelQueued(ctx context.Context, repo *v1alpha1.Repository, run Queueable) string {
+	qm.lock.Lock()
+	defer qm.lock.Unlock()
+
+	return qm.removeAndAdvance(ctx, repo, getQueueKey(run))
+}
+
+// ForceAcquire implements queue-request=force: it grants run a running
+// slot even above ConcurrencyLimit, as a repo-admin escape hatch for a
+// stuck queue. It logs a warning and records a ConcurrencyOverridden
+// Warning event on the Repository.
+func (qm *QueueManager) ForceAcquire(ctx context.Context, repo *v1alpha1.Repository, run Queueable) (bool, error) {
+	qm.lock.Lock()
+	defer qm.lock.Unlock()
+
+	sema, err := qm.getSemaphore(repo)
+	if err != nil {
+		return false, err
+	}
+
+	qKey := getQueueKey(run)
+	if err := sema.forceAcquire(ctx, qKey); err != nil {
+		return false, err
+	}
+	qm.logger.Warnf("ConcurrencyOverridden: force-acquired a slot for (%s) above the configured limit for repository (%s)", qKey, repoKey(repo))
+	qm.recorder.Eventf(repo, corev1.EventTypeWarning, "ConcurrencyOverridden", "PipelineRun %s force-acquired a slot above the configured ConcurrencyLimit", qKey)
+	return true, nil
+}
+
+// queueRequest is the value of queueRequestAnnotation: a k8s-native
+// escape hatch for repo admins to unstick a queue without editing the
+// Repository CR's ConcurrencyLimit.
+type queueRequest string
+
+const (
+	queueRequestPromote queueRequest = "promote"
+	queueRequestCancel  queueRequest = "cancel"
+	queueRequestForce   queueRequest = "force"
+)
+
+// queueRequestAnnotation on a PipelineRun asks the reconciler to bypass
+// normal queueing: "promote" jumps the queue, "cancel" drops it without
+// ever running, "force" acquires a slot above ConcurrencyLimit.
+const queueRequestAnnotation = pipelinesascode.GroupName + "/queue-request"
+
+// HandleQueueRequest inspects run's queueRequestAnnotation, if any, and
+// applies the corresponding QueueManager operation. It returns false with
+// no error if the annotation is absent or unrecognised, meaning normal
+// queueing (AddToQueue) should proceed.
+func (qm *QueueManager) HandleQueueRequest(ctx context.Context, repo *v1alpha1.Repository, run Queueable) (handled, acquired bool, msg string, err error) {
+	switch queueRequest(run.GetAnnotations()[queueRequestAnnotation]) {
+	case queueRequestPromote:
+		acquired, msg, err = qm.Promote(ctx, repo, run)
+		return true, acquired, msg, err
+	case queueRequestCancel:
+		qm.CancelQueued(ctx, repo, run)
+		return true, false, "removed from queue by queue-request=cancel", nil
+	case queueRequestForce:
+		acquired, err = qm.ForceAcquire(ctx, repo, run)
+		return true, acquired, "", err
+	default:
+		return false, false, "", nil
+	}
+}
+
+func getQueueKey(run Queueable) string {
+	return fmt.Sprintf("%s/%s", run.GetNamespace(), run.GetName())
 }
 
-// InitQueues rebuild all the queues for all repository if concurrency is defined before
-// reconciler started reconciling them
+// queuePriorityAnnotation lets a PipelineRun jump ahead of the strict
+// FIFO order, e.g. for a /retest on a release branch behind a large
+// backlog. Higher values run sooner. It is only read from the run; when
+// it is unset or not an integer, the Repository's Spec.QueuePriority is
+// used, and priority 0 when that is nil too.
+const queuePriorityAnnotation = pipelinesascode.GroupName + "/queue-priority"
+
+// queuePriority resolves the effective priority for run: its own
+// annotation if set and valid, otherwise the Repository's
+// Spec.QueuePriority default.
+func queuePriority(run Queueable, repo *v1alpha1.Repository) int {
+	if v, ok := run.GetAnnotations()[queuePriorityAnnotation]; ok {
+		if p, err := strconv.Atoi(v); err == nil {
+			return p
+		}
+	}
+	if repo.Spec.QueuePriority != nil {
+		return *repo.Spec.QueuePriority
+	}
+	return 0
+}
+
+// InitQueues reconciles the waiting/running queues for all repositories
+// that have concurrency defined, against both the cluster's PipelineRuns
+// and (for shared backends) whichever replica's holders are already
+// recorded, before the reconciler starts reconciling them. This avoids a
+// restarting controller stomping on slots another replica is still
+// legitimately holding.
 func (qm *QueueManager) InitQueues(ctx context.Context, tekton versioned2.Interface, pac versioned.Interface) error {
+	// Hold qm.lock for the whole reconciliation: it mutates queueMap and
+	// repoByKey via getSemaphore exactly like every other QueueManager
+	// method, and purgeExpiredLoop's background goroutine is already
+	// running and reading those same maps under qm.lock by the time this
+	// runs.
+	qm.lock.Lock()
+	defer qm.lock.Unlock()
+
 	// fetch all repos
 	repos, err := pac.PipelinesascodeV1alpha1().Repositories("").List(ctx, v1.ListOptions{})
 	if err != nil {
@@ -151,7 +403,7 @@ func (qm *QueueManager) InitQueues(ctx context.Context, tekton versioned2.Interf
 			}
 
 			qKey := getQueueKey(&pr)
-			sema.addToQueue(qKey, pr.CreationTimestamp.Time)
+			sema.addToQueue(qKey, pr.CreationTimestamp.Time, queuePriority(&pr, &repo))
 		}
 
 		// now fetch all started pipelineRun and update the running queue
@@ -169,8 +421,66 @@ func (qm *QueueManager) InitQueues(ctx context.Context, tekton versioned2.Interf
 			if err != nil {
 				return err
 			}
-			sema.acquire(getQueueKey(&pr))
+			sema.acquire(ctx, getQueueKey(&pr))
+		}
+
+		// custom Tekton Runs (e.g. from a pipeline step delegating to a
+		// custom task controller) count against the same ConcurrencyLimit,
+		// so queue and admit them exactly like PipelineRuns.
+		runs, err := tekton.RunV1alpha1().Runs(repo.Namespace).
+			List(ctx, v1.ListOptions{
+				LabelSelector: fmt.Sprintf("%s/%s=%s", pipelinesascode.GroupName, "state", kubeinteraction.StateQueued),
+			})
+		if err != nil {
+			return err
+		}
+
+		for _, run := range runs.Items {
+			run := run
+			sema, err := qm.getSemaphore(&repo)
+			if err != nil {
+				return err
+			}
+
+			qKey := getQueueKey(&run)
+			sema.addToQueue(qKey, run.GetCreationTimestamp().Time, queuePriority(&run, &repo))
+		}
+
+		runs, err = tekton.RunV1alpha1().Runs(repo.Namespace).
+			List(ctx, v1.ListOptions{
+				LabelSelector: fmt.Sprintf("%s/%s=%s", pipelinesascode.GroupName, "state", kubeinteraction.StateStarted),
+			})
+		if err != nil {
+			return err
 		}
+
+		for _, run := range runs.Items {
+			run := run
+			sema, err := qm.getSemaphore(&repo)
+			if err != nil {
+				return err
+			}
+			sema.acquire(ctx, getQueueKey(&run))
+		}
+
+		// reconcile against whatever the backend already considers
+		// held (e.g. another still-live replica), so we don't
+		// over-admit runs this replica doesn't know about yet.
+		holders, err := qm.backend.Holders(ctx, repoKey(&repo))
+		if err != nil {
+			return err
+		}
+		sema, err := qm.getSemaphore(&repo)
+		if err != nil {
+			return err
+		}
+		for _, holder := range holders {
+			sema.acquire(ctx, holder)
+		}
+
+		// drop anything that was already stale before this replica even
+		// started, rather than resurrecting a long-dead queue entry.
+		sema.purgeExpired(time.Now())
 	}
 
 	return nil
@@ -184,6 +494,10 @@ func (qm *QueueManager) RemoveRepository(repo *v1alpha1.Repository) {
 	delete(qm.queueMap, repoKey)
 }
 
+// QueuedPipelineRuns returns the names of the waiting PipelineRuns in the
+// order they will actually be admitted, i.e. by (-priority,
+// creationTimestamp), so callers like `tkn pac describe` show the real
+// run order rather than plain FIFO.
 func (qm *QueueManager) QueuedPipelineRuns(repo *v1alpha1.Repository) []string {
 	qm.lock.Lock()
 	defer qm.lock.Unlock()
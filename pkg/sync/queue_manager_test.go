@@ -0,0 +1,232 @@
+package sync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/openshift-pipelines/pipelines-as-code/pkg/apis/pipelinesascode/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1beta1"
+	runv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/run/v1alpha1"
+	"go.uber.org/zap"
+	"gotest.tools/v3/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func newTestQueueManager(t *testing.T) *QueueManager {
+	t.Helper()
+	qm, err := NewQueueManager(context.Background(), zap.NewNop().Sugar(), BackendMemory, nil, "", record.NewFakeRecorder(100), nil)
+	assert.NilError(t, err)
+	return qm
+}
+
+func testRepo(limit int) *v1alpha1.Repository {
+	return &v1alpha1.Repository{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "repo"},
+		Spec:       v1alpha1.RepositorySpec{ConcurrencyLimit: &limit},
+	}
+}
+
+// TestQueueManager_PipelineRunAndRunShareLimit mixes a PipelineRun and a
+// custom-task Run under the same Repository with ConcurrencyLimit: 1 and
+// asserts they are serialized, not both admitted at once.
+func TestQueueManager_PipelineRunAndRunShareLimit(t *testing.T) {
+	ctx := context.Background()
+	qm := newTestQueueManager(t)
+	repo := testRepo(1)
+
+	pr := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "pr1"}}
+	run := &runv1alpha1.Run{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "run1"}}
+
+	acquired, _, err := qm.AddToQueue(ctx, repo, pr)
+	assert.NilError(t, err)
+	assert.Assert(t, acquired, "first PipelineRun should be admitted immediately")
+
+	acquired, _, err = qm.AddToQueue(ctx, repo, run)
+	assert.NilError(t, err)
+	assert.Assert(t, !acquired, "Run should be queued behind the running PipelineRun")
+
+	next := qm.RemoveFromQueue(ctx, repo, pr)
+	assert.Equal(t, next, getQueueKey(run), "Run should be promoted once the PipelineRun finishes")
+
+	assert.DeepEqual(t, qm.RunningPipelineRuns(repo), []string{getQueueKey(run)})
+	assert.DeepEqual(t, qm.QueuedPipelineRuns(repo), []string{})
+}
+
+// TestQueueManager_PriorityOrdering asserts that queued runs are
+// admitted by (-priority, creationTimestamp): a later-created but
+// higher-priority run jumps ahead of an earlier, lower-priority one, and
+// the Repository's Spec.QueuePriority acts as the default for runs with
+// no priority annotation of their own.
+func TestQueueManager_PriorityOrdering(t *testing.T) {
+	ctx := context.Background()
+	qm := newTestQueueManager(t)
+
+	repo := testRepo(1)
+	defaultPriority := 1
+	repo.Spec.QueuePriority = &defaultPriority
+
+	now := metav1.Now()
+	later := metav1.NewTime(now.Add(time.Minute))
+
+	running := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "running", CreationTimestamp: now}}
+	low := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "low", CreationTimestamp: now}}
+	high := &v1beta1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "ns",
+			Name:              "high",
+			CreationTimestamp: later,
+			Annotations:       map[string]string{queuePriorityAnnotation: "5"},
+		},
+	}
+
+	acquired, _, err := qm.AddToQueue(ctx, repo, running)
+	assert.NilError(t, err)
+	assert.Assert(t, acquired)
+
+	acquired, _, err = qm.AddToQueue(ctx, repo, low)
+	assert.NilError(t, err)
+	assert.Assert(t, !acquired, "low should queue behind the running PipelineRun")
+
+	acquired, _, err = qm.AddToQueue(ctx, repo, high)
+	assert.NilError(t, err)
+	assert.Assert(t, !acquired, "high should still queue: running still holds the only slot")
+
+	assert.DeepEqual(t, qm.QueuedPipelineRuns(repo), []string{getQueueKey(high), getQueueKey(low)})
+}
+
+// TestQueueManager_Promote asserts that Promote jumps run to the head of
+// the waiting queue, ahead of the usual (-priority, creationTimestamp)
+// ordering, and admits it once it's at the top.
+func TestQueueManager_Promote(t *testing.T) {
+	ctx := context.Background()
+	qm := newTestQueueManager(t)
+	repo := testRepo(1)
+
+	running := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "running"}}
+	first := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "first"}}
+	promoted := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "promoted"}}
+
+	_, _, err := qm.AddToQueue(ctx, repo, running)
+	assert.NilError(t, err)
+	_, _, err = qm.AddToQueue(ctx, repo, first)
+	assert.NilError(t, err)
+	_, _, err = qm.AddToQueue(ctx, repo, promoted)
+	assert.NilError(t, err)
+
+	acquired, _, err := qm.Promote(ctx, repo, promoted)
+	assert.NilError(t, err)
+	assert.Assert(t, !acquired, "still behind the running PipelineRun")
+	assert.DeepEqual(t, qm.QueuedPipelineRuns(repo), []string{getQueueKey(promoted), getQueueKey(first)})
+
+	// A later arrival re-sorts the whole waiting queue; the promotion
+	// must survive that re-sort.
+	latecomer := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "latecomer"}}
+	_, _, err = qm.AddToQueue(ctx, repo, latecomer)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, qm.QueuedPipelineRuns(repo), []string{getQueueKey(promoted), getQueueKey(first), getQueueKey(latecomer)})
+
+	next := qm.RemoveFromQueue(ctx, repo, running)
+	assert.Equal(t, next, getQueueKey(promoted), "promoted run should be admitted first")
+}
+
+// TestQueueManager_CancelQueued asserts that CancelQueued drops a waiting
+// run without ever admitting it, and advances whichever run was next.
+func TestQueueManager_CancelQueued(t *testing.T) {
+	ctx := context.Background()
+	qm := newTestQueueManager(t)
+	repo := testRepo(1)
+
+	running := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "running"}}
+	cancelled := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "cancelled"}}
+
+	_, _, err := qm.AddToQueue(ctx, repo, running)
+	assert.NilError(t, err)
+	acquired, _, err := qm.AddToQueue(ctx, repo, cancelled)
+	assert.NilError(t, err)
+	assert.Assert(t, !acquired)
+
+	next := qm.CancelQueued(ctx, repo, cancelled)
+	assert.Equal(t, next, "", "nothing else was waiting")
+	assert.DeepEqual(t, qm.QueuedPipelineRuns(repo), []string{})
+	assert.DeepEqual(t, qm.RunningPipelineRuns(repo), []string{getQueueKey(running)})
+}
+
+// TestQueueManager_ForceAcquire asserts that ForceAcquire admits run even
+// when the repository's ConcurrencyLimit is already exhausted.
+func TestQueueManager_ForceAcquire(t *testing.T) {
+	ctx := context.Background()
+	qm := newTestQueueManager(t)
+	repo := testRepo(1)
+
+	running := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "running"}}
+	forced := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "forced"}}
+
+	_, _, err := qm.AddToQueue(ctx, repo, running)
+	assert.NilError(t, err)
+
+	acquired, err := qm.ForceAcquire(ctx, repo, forced)
+	assert.NilError(t, err)
+	assert.Assert(t, acquired)
+
+	runningNow := qm.RunningPipelineRuns(repo)
+	assert.Equal(t, len(runningNow), 2, "force-acquire should run above ConcurrencyLimit")
+}
+
+// TestQueueManager_HandleQueueRequest asserts that the queue-request
+// annotation dispatches to the matching QueueManager operation, and that
+// an unrecognised (or absent) value leaves normal queueing to the
+// caller.
+func TestQueueManager_HandleQueueRequest(t *testing.T) {
+	ctx := context.Background()
+	qm := newTestQueueManager(t)
+	repo := testRepo(1)
+
+	running := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "running"}}
+	_, _, err := qm.AddToQueue(ctx, repo, running)
+	assert.NilError(t, err)
+
+	forced := &v1beta1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "ns",
+			Name:        "forced",
+			Annotations: map[string]string{queueRequestAnnotation: string(queueRequestForce)},
+		},
+	}
+	handled, acquired, _, err := qm.HandleQueueRequest(ctx, repo, forced)
+	assert.NilError(t, err)
+	assert.Assert(t, handled)
+	assert.Assert(t, acquired)
+
+	plain := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "plain"}}
+	handled, _, _, err = qm.HandleQueueRequest(ctx, repo, plain)
+	assert.NilError(t, err)
+	assert.Assert(t, !handled, "no queue-request annotation means normal queueing should proceed")
+}
+
+// TestQueueManager_PurgeExpired asserts that a waiting run past its
+// Repository's ConcurrencyMaxWait is evicted from the queue.
+func TestQueueManager_PurgeExpired(t *testing.T) {
+	ctx := context.Background()
+	qm := newTestQueueManager(t)
+
+	repo := testRepo(1)
+	repo.Spec.ConcurrencyMaxWait = &metav1.Duration{Duration: 10 * time.Millisecond}
+
+	running := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "running"}}
+	waiting := &v1beta1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "waiting"}}
+
+	acquired, _, err := qm.AddToQueue(ctx, repo, running)
+	assert.NilError(t, err)
+	assert.Assert(t, acquired)
+
+	acquired, _, err = qm.AddToQueue(ctx, repo, waiting)
+	assert.NilError(t, err)
+	assert.Assert(t, !acquired)
+
+	time.Sleep(20 * time.Millisecond)
+	qm.purgeExpiredOnce(ctx)
+
+	assert.DeepEqual(t, qm.QueuedPipelineRuns(repo), []string{})
+}
@@ -0,0 +1,18 @@
+package sync
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Queueable is the subset of metav1.Object the QueueManager needs from
+// whatever is being admitted. Both *v1beta1.PipelineRun and
+// *runv1alpha1.Run satisfy it, so a Tekton custom Run created from a
+// PaC-managed pipeline is subject to ConcurrencyLimit exactly like a
+// PipelineRun is.
+type Queueable interface {
+	GetNamespace() string
+	GetName() string
+	GetCreationTimestamp() metav1.Time
+	GetLabels() map[string]string
+	GetAnnotations() map[string]string
+}
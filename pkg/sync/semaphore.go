@@ -0,0 +1,304 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Semaphore tracks the waiting and running PipelineRuns for a single
+// repository. Ordering of the waiting queue is kept in-process (it is
+// cheap and only needs to be consistent for the controller evaluating
+// it), while admission itself (is there a free slot right now) is
+// delegated to a QueueBackend so that it can be shared across replicas.
+type Semaphore interface {
+	// addToQueue enqueues key. Ordering is by (-priority, creationTime):
+	// higher priority runs first; among equal priorities, FIFO by
+	// creationTime.
+	addToQueue(key string, creationTime time.Time, priority int)
+	removeFromQueue(key string)
+	tryAcquire(ctx context.Context, key string) (bool, string)
+	// acquire registers key as already running (e.g. recovered on
+	// InitQueues) directly with the backend, bypassing the waiting
+	// queue, and starts its heartbeat renewal.
+	acquire(ctx context.Context, key string)
+	acquireLatest(ctx context.Context) string
+	release(key string)
+	// promote moves key to the head of the waiting queue, ahead of the
+	// usual (-priority, creationTime) ordering, so a subsequent
+	// tryAcquire considers it first. The promotion sticks: a later
+	// addToQueue re-sorting the whole slice cannot push key back behind
+	// a new arrival.
+	promote(key string)
+	// forceAcquire unconditionally grants key a running slot, even
+	// above limit, bypassing the waiting queue and the backend's normal
+	// admission check.
+	forceAcquire(ctx context.Context, key string) error
+	getLimit() int
+	resize(limit int) bool
+	// setMaxWait configures how long an entry may sit in the waiting
+	// queue before purgeExpired evicts it. Zero disables eviction.
+	setMaxWait(d time.Duration)
+	// purgeExpired drops waiting entries whose age since creationTime
+	// exceeds maxWait (as of now) and returns their keys, for the caller
+	// to cancel and report on.
+	purgeExpired(now time.Time) []string
+	getCurrentPending() []string
+	getCurrentRunning() []string
+}
+
+type queuedItem struct {
+	key          string
+	creationTime time.Time
+	priority     int
+	queuedAt     time.Time // when addToQueue first saw this key, for pac_queue_wait_seconds
+	// promoted marks an entry moved to the head of the queue by
+	// queue-request=promote. sortWaiting keeps every promoted entry
+	// ahead of every non-promoted one, so a later addToQueue (which
+	// re-sorts the whole slice) can't silently undo the promotion.
+	promoted bool
+}
+
+// inMemorySemaphore is the default Semaphore implementation. It keeps the
+// waiting/running bookkeeping local to this process and asks a
+// QueueBackend to decide admission, so it behaves correctly whether that
+// backend is itself in-memory or shared (e.g. Redis).
+type inMemorySemaphore struct {
+	repoKey string
+	limit   int
+	backend QueueBackend
+	ttl     time.Duration
+	maxWait time.Duration
+
+	waiting []queuedItem
+	running map[string]struct{}
+	cancels map[string]context.CancelFunc
+}
+
+func newSemaphore(repoKey string, limit int, backend QueueBackend) Semaphore {
+	return &inMemorySemaphore{
+		repoKey: repoKey,
+		limit:   limit,
+		backend: backend,
+		ttl:     defaultLeaseTTL,
+		running: map[string]struct{}{},
+		cancels: map[string]context.CancelFunc{},
+	}
+}
+
+// startHeartbeat renews key's backend lease at half its TTL until
+// stopHeartbeat is called for it, so a live controller never loses a
+// slot to evictExpired while it's genuinely still running; a crashed one
+// simply stops renewing and the lease still expires on its own.
+func (s *inMemorySemaphore) startHeartbeat(key string) {
+	if _, ok := s.cancels[key]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancels[key] = cancel
+
+	go func() {
+		ticker := time.NewTicker(s.ttl / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// ErrLeaseLost means the backend already reclaimed this
+				// slot (e.g. a renew was missed for longer than the
+				// TTL): stop renewing rather than let Renew resurrect
+				// it out from under whoever took it next. The local
+				// running/cancels bookkeeping is cleaned up normally
+				// once the caller eventually releases this key.
+				if err := s.backend.Renew(ctx, s.repoKey, key, s.ttl); err == ErrLeaseLost {
+					return
+				}
+			}
+		}
+	}()
+}
+
+func (s *inMemorySemaphore) stopHeartbeat(key string) {
+	if cancel, ok := s.cancels[key]; ok {
+		cancel()
+		delete(s.cancels, key)
+	}
+}
+
+func (s *inMemorySemaphore) addToQueue(key string, creationTime time.Time, priority int) {
+	for _, it := range s.waiting {
+		if it.key == key {
+			return
+		}
+	}
+	s.waiting = append(s.waiting, queuedItem{key: key, creationTime: creationTime, priority: priority, queuedAt: time.Now()})
+	s.sortWaiting()
+	recordQueueGauges(s.repoKey, len(s.waiting), len(s.running), s.limit)
+}
+
+// sortWaiting orders promoted entries ahead of all others, then by
+// (-priority, creationTime): higher priority first, and FIFO by creation
+// time within the same priority.
+func (s *inMemorySemaphore) sortWaiting() {
+	sort.SliceStable(s.waiting, func(i, j int) bool {
+		if s.waiting[i].promoted != s.waiting[j].promoted {
+			return s.waiting[i].promoted
+		}
+		if s.waiting[i].priority != s.waiting[j].priority {
+			return s.waiting[i].priority > s.waiting[j].priority
+		}
+		return s.waiting[i].creationTime.Before(s.waiting[j].creationTime)
+	})
+}
+
+func (s *inMemorySemaphore) removeFromQueue(key string) {
+	for i, it := range s.waiting {
+		if it.key == key {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			recordQueueGauges(s.repoKey, len(s.waiting), len(s.running), s.limit)
+			return
+		}
+	}
+}
+
+// tryAcquire checks whether key is at the head of the local waiting queue
+// and, if so, asks the backend for a cluster-wide slot.
+func (s *inMemorySemaphore) tryAcquire(ctx context.Context, key string) (bool, string) {
+	if len(s.waiting) == 0 || s.waiting[0].key != key {
+		return false, "not at the top of the queue"
+	}
+
+	acquired, position, err := s.backend.TryAcquire(ctx, s.repoKey, key, s.ttl, s.limit)
+	if err != nil {
+		return false, err.Error()
+	}
+	if !acquired {
+		return false, fmt.Sprintf("waiting for a free slot (position %d)", position)
+	}
+
+	queuedAt := s.waiting[0].queuedAt
+	s.removeFromQueue(key)
+	s.running[key] = struct{}{}
+	s.startHeartbeat(key)
+
+	recordAdmitted(s.repoKey, time.Since(queuedAt).Seconds())
+	recordQueueGauges(s.repoKey, len(s.waiting), len(s.running), s.limit)
+	return true, ""
+}
+
+// acquireLatest tries to admit the entry at the head of the waiting
+// queue, returning its key if it was admitted. An entry that is not
+// admitted stays at the head of the queue.
+func (s *inMemorySemaphore) acquireLatest(ctx context.Context) string {
+	if len(s.waiting) == 0 {
+		return ""
+	}
+	next := s.waiting[0].key
+	if acquired, _ := s.tryAcquire(ctx, next); acquired {
+		return next
+	}
+	return ""
+}
+
+// acquire registers key as already running directly with the backend
+// (e.g. a run recovered by InitQueues), rather than going through the
+// waiting queue and tryAcquire, and starts its heartbeat renewal. Force
+// is used rather than TryAcquire because the run is already running
+// regardless of limit: InitQueues is reconciling known state, not
+// deciding admission.
+func (s *inMemorySemaphore) acquire(ctx context.Context, key string) {
+	if err := s.backend.Force(ctx, s.repoKey, key, s.ttl); err != nil {
+		return
+	}
+	s.running[key] = struct{}{}
+	s.startHeartbeat(key)
+	recordQueueGauges(s.repoKey, len(s.waiting), len(s.running), s.limit)
+}
+
+func (s *inMemorySemaphore) promote(key string) {
+	for i, it := range s.waiting {
+		if it.key == key {
+			s.waiting[i].promoted = true
+			s.sortWaiting()
+			return
+		}
+	}
+}
+
+func (s *inMemorySemaphore) forceAcquire(ctx context.Context, key string) error {
+	if err := s.backend.Force(ctx, s.repoKey, key, s.ttl); err != nil {
+		return err
+	}
+	s.removeFromQueue(key)
+	s.running[key] = struct{}{}
+	s.startHeartbeat(key)
+	return nil
+}
+
+func (s *inMemorySemaphore) release(key string) {
+	delete(s.running, key)
+	s.stopHeartbeat(key)
+	_ = s.backend.Release(context.Background(), s.repoKey, key)
+	recordQueueGauges(s.repoKey, len(s.waiting), len(s.running), s.limit)
+}
+
+func (s *inMemorySemaphore) getLimit() int {
+	return s.limit
+}
+
+func (s *inMemorySemaphore) resize(limit int) bool {
+	s.limit = limit
+	ok := s.backend.Resize(context.Background(), s.repoKey, limit) == nil
+	recordQueueGauges(s.repoKey, len(s.waiting), len(s.running), s.limit)
+	return ok
+}
+
+func (s *inMemorySemaphore) setMaxWait(d time.Duration) {
+	s.maxWait = d
+}
+
+// purgeExpired evicts waiting entries whose age (now - creationTime)
+// exceeds maxWait, returning their keys. Age is measured from creation
+// rather than from when this process queued the entry, so entries
+// re-queued by InitQueues after a restart keep their real age. It never
+// touches the running set: admitted runs are bounded by their lease TTL,
+// not ConcurrencyMaxWait.
+func (s *inMemorySemaphore) purgeExpired(now time.Time) []string {
+	if s.maxWait <= 0 || len(s.waiting) == 0 {
+		return nil
+	}
+
+	var expired []string
+	kept := s.waiting[:0]
+	for _, it := range s.waiting {
+		if now.Sub(it.creationTime) > s.maxWait {
+			expired = append(expired, it.key)
+			continue
+		}
+		kept = append(kept, it)
+	}
+	s.waiting = kept
+
+	if len(expired) > 0 {
+		recordQueueGauges(s.repoKey, len(s.waiting), len(s.running), s.limit)
+	}
+	return expired
+}
+
+func (s *inMemorySemaphore) getCurrentPending() []string {
+	out := make([]string, 0, len(s.waiting))
+	for _, it := range s.waiting {
+		out = append(out, it.key)
+	}
+	return out
+}
+
+func (s *inMemorySemaphore) getCurrentRunning() []string {
+	out := make([]string, 0, len(s.running))
+	for k := range s.running {
+		out = append(out, k)
+	}
+	return out
+}